@@ -0,0 +1,125 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package event defines the typed measurements that flow from a mapped
+// Graphite line into a registry. An Event knows its own Prometheus metric
+// type; a Registry just knows how to hand back the right collector for a
+// given name, label set and TTL.
+package event
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+// Registry is the subset of registry.Registry that an Event needs in order
+// to apply itself. Keeping it as an interface here, rather than importing
+// pkg/registry, is what lets pkg/event stay free of any storage concerns.
+type Registry interface {
+	GetCounter(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Counter, error)
+	GetGauge(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Gauge, error)
+	GetHistogram(name, help string, labels prometheus.Labels, buckets []float64, ttl time.Duration) (prometheus.Observer, error)
+	GetSummary(name, help string, labels prometheus.Labels, opts *mapper.SummaryOptions, ttl time.Duration) (prometheus.Observer, error)
+}
+
+// Event is a single resolved measurement ready to be applied to a Registry.
+type Event interface {
+	MetricName() string
+	Labels() prometheus.Labels
+	Handle(r Registry) error
+}
+
+// Events is a batch of events, e.g. decoded from one pickle frame.
+type Events []Event
+
+type base struct {
+	Name      string
+	Help      string
+	LabelSet  prometheus.Labels
+	Value     float64
+	Timestamp time.Time
+	TTL       time.Duration
+}
+
+func (b base) MetricName() string        { return b.Name }
+func (b base) Labels() prometheus.Labels { return b.LabelSet }
+
+// CounterEvent adds Value to a monotonic counter.
+type CounterEvent struct{ base }
+
+func NewCounterEvent(name, help string, labels prometheus.Labels, value float64, ts time.Time, ttl time.Duration) CounterEvent {
+	return CounterEvent{base{Name: name, Help: help, LabelSet: labels, Value: value, Timestamp: ts, TTL: ttl}}
+}
+
+func (e CounterEvent) Handle(r Registry) error {
+	c, err := r.GetCounter(e.Name, e.Help, e.LabelSet, e.TTL)
+	if err != nil {
+		return err
+	}
+	c.Add(e.Value)
+	return nil
+}
+
+// GaugeEvent sets a gauge to Value.
+type GaugeEvent struct{ base }
+
+func NewGaugeEvent(name, help string, labels prometheus.Labels, value float64, ts time.Time, ttl time.Duration) GaugeEvent {
+	return GaugeEvent{base{Name: name, Help: help, LabelSet: labels, Value: value, Timestamp: ts, TTL: ttl}}
+}
+
+func (e GaugeEvent) Handle(r Registry) error {
+	g, err := r.GetGauge(e.Name, e.Help, e.LabelSet, e.TTL)
+	if err != nil {
+		return err
+	}
+	g.Set(e.Value)
+	return nil
+}
+
+// TimerEvent observes Value into a histogram or a summary, depending on the
+// mapping's observer_type. Graphite has no native timer type, so this is how
+// histogram_options/summary_options reach the registry.
+type TimerEvent struct {
+	base
+	ObserverType mapper.ObserverType
+	Buckets      []float64
+	Summary      *mapper.SummaryOptions
+}
+
+func NewTimerEvent(name, help string, labels prometheus.Labels, value float64, ts time.Time, ttl time.Duration, observerType mapper.ObserverType, buckets []float64, summary *mapper.SummaryOptions) TimerEvent {
+	return TimerEvent{
+		base:         base{Name: name, Help: help, LabelSet: labels, Value: value, Timestamp: ts, TTL: ttl},
+		ObserverType: observerType,
+		Buckets:      buckets,
+		Summary:      summary,
+	}
+}
+
+func (e TimerEvent) Handle(r Registry) error {
+	if e.ObserverType == mapper.ObserverTypeHistogram {
+		h, err := r.GetHistogram(e.Name, e.Help, e.LabelSet, e.Buckets, e.TTL)
+		if err != nil {
+			return err
+		}
+		h.Observe(e.Value)
+		return nil
+	}
+	s, err := r.GetSummary(e.Name, e.Help, e.LabelSet, e.Summary, e.TTL)
+	if err != nil {
+		return err
+	}
+	s.Observe(e.Value)
+	return nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+// fakeRegistry is a minimal event.Registry that hands back bare
+// client_golang collectors, so Handle's plumbing can be tested without
+// pkg/registry.
+type fakeRegistry struct {
+	counter   prometheus.Counter
+	gauge     prometheus.Gauge
+	histogram prometheus.Histogram
+	summary   prometheus.Summary
+
+	lastTTL time.Duration
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{
+		counter:   prometheus.NewCounter(prometheus.CounterOpts{Name: "c"}),
+		gauge:     prometheus.NewGauge(prometheus.GaugeOpts{Name: "g"}),
+		histogram: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "h"}),
+		summary:   prometheus.NewSummary(prometheus.SummaryOpts{Name: "s"}),
+	}
+}
+
+func (f *fakeRegistry) GetCounter(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Counter, error) {
+	f.lastTTL = ttl
+	return f.counter, nil
+}
+
+func (f *fakeRegistry) GetGauge(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Gauge, error) {
+	f.lastTTL = ttl
+	return f.gauge, nil
+}
+
+func (f *fakeRegistry) GetHistogram(name, help string, labels prometheus.Labels, buckets []float64, ttl time.Duration) (prometheus.Observer, error) {
+	f.lastTTL = ttl
+	return f.histogram, nil
+}
+
+func (f *fakeRegistry) GetSummary(name, help string, labels prometheus.Labels, opts *mapper.SummaryOptions, ttl time.Duration) (prometheus.Observer, error) {
+	f.lastTTL = ttl
+	return f.summary, nil
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}
+
+func gaugeValue(g prometheus.Gauge) float64 {
+	var m dto.Metric
+	g.Write(&m)
+	return m.GetGauge().GetValue()
+}
+
+func histogramSampleCount(h prometheus.Histogram) uint64 {
+	var m dto.Metric
+	h.Write(&m)
+	return m.GetHistogram().GetSampleCount()
+}
+
+func summarySampleCount(s prometheus.Summary) uint64 {
+	var m dto.Metric
+	s.Write(&m)
+	return m.GetSummary().GetSampleCount()
+}
+
+func TestCounterEventHandle(t *testing.T) {
+	r := newFakeRegistry()
+	ev := NewCounterEvent("c", "help", nil, 3, time.Now(), 5*time.Minute)
+	if err := ev.Handle(r); err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+	if got := counterValue(r.counter); got != 3 {
+		t.Errorf("counter value = %v, want 3", got)
+	}
+	if r.lastTTL != 5*time.Minute {
+		t.Errorf("ttl passed to GetCounter = %v, want 5m", r.lastTTL)
+	}
+}
+
+func TestGaugeEventHandle(t *testing.T) {
+	r := newFakeRegistry()
+	ev := NewGaugeEvent("g", "help", nil, 42, time.Now(), 0)
+	if err := ev.Handle(r); err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+	if got := gaugeValue(r.gauge); got != 42 {
+		t.Errorf("gauge value = %v, want 42", got)
+	}
+}
+
+func TestTimerEventHandleHistogram(t *testing.T) {
+	r := newFakeRegistry()
+	ev := NewTimerEvent("t", "help", nil, 1.5, time.Now(), 0, mapper.ObserverTypeHistogram, nil, nil)
+	if err := ev.Handle(r); err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+	if got := histogramSampleCount(r.histogram); got != 1 {
+		t.Errorf("histogram sample count = %v, want 1", got)
+	}
+}
+
+func TestTimerEventHandleSummary(t *testing.T) {
+	r := newFakeRegistry()
+	ev := NewTimerEvent("t", "help", nil, 1.5, time.Now(), 0, mapper.ObserverTypeSummary, nil, nil)
+	if err := ev.Handle(r); err != nil {
+		t.Fatalf("Handle returned an error: %v", err)
+	}
+	if got := summarySampleCount(r.summary); got != 1 {
+		t.Errorf("summary sample count = %v, want 1", got)
+	}
+}
+
+func TestBaseMetricNameAndLabels(t *testing.T) {
+	labels := prometheus.Labels{"env": "prod"}
+	ev := NewGaugeEvent("g", "help", labels, 1, time.Now(), 0)
+	if ev.MetricName() != "g" {
+		t.Errorf("MetricName() = %q, want %q", ev.MetricName(), "g")
+	}
+	if ev.Labels()["env"] != "prod" {
+		t.Errorf("Labels() = %+v, want env=prod", ev.Labels())
+	}
+}
@@ -0,0 +1,274 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package exporter wires pkg/listener, pkg/line, pkg/event and pkg/registry
+// together into a prometheus.Collector, the way statsd_exporter wires its
+// own equivalents. Consumers who only want the parsing or the registry can
+// import those packages directly instead of pulling in the whole exporter.
+package exporter
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+
+	"github.com/vmiszczak-teads/graphite_exporter/pkg/event"
+	"github.com/vmiszczak-teads/graphite_exporter/pkg/line"
+	"github.com/vmiszczak-teads/graphite_exporter/pkg/listener"
+	"github.com/vmiszczak-teads/graphite_exporter/pkg/mappercache"
+	"github.com/vmiszczak-teads/graphite_exporter/pkg/registry"
+)
+
+// Mapper resolves a metric name into a statsd-style mapping rule.
+type Mapper interface {
+	GetMapping(string, mapper.MetricType) (*mapper.MetricMapping, prometheus.Labels, bool)
+	InitFromFile(fileName string) error
+}
+
+// Exporter turns Graphite plaintext lines into Prometheus metrics.
+type Exporter struct {
+	mapper       Mapper
+	registry     *registry.Registry
+	lineCh       chan string
+	strictMatch  bool
+	logger       log.Logger
+	sampleExpiry time.Duration
+
+	tagParseFailures   prometheus.Counter
+	lastProcessed      prometheus.Gauge
+	sampleExpiryMetric prometheus.Gauge
+	exposeTimestamps   bool
+
+	// listeners holds every network listener this Exporter has started,
+	// so their own metrics (connection counts, parse failures, ...) are
+	// collected alongside the exporter's.
+	listeners []prometheus.Collector
+
+	// mapperCache is non-nil when --graphite.cache-size is positive; its
+	// own hit/miss/eviction metrics are collected alongside the exporter's.
+	mapperCache *mappercache.Cache
+	cacheSize   int
+	cachePolicy mappercache.EvictionPolicy
+
+	// legacyGSplit restores the pre-existing, always-on gsplit_N label
+	// extraction for mappings that set no labels of their own, for
+	// --graphite.legacy-gsplit, without the old trailing "." on the last
+	// value.
+	legacyGSplit bool
+}
+
+// New creates an Exporter and starts its line-processing goroutine.
+//
+// cacheSize and cachePolicy configure the bounded cache placed in front of
+// whatever Mapper is later passed to SetMapper (see --graphite.cache-size
+// and --graphite.cache-eviction-policy); a cacheSize of 0 disables caching.
+// legacyGSplit corresponds to --graphite.legacy-gsplit.
+func New(logger log.Logger, strictMatch bool, sampleExpiry time.Duration, cacheSize int, cachePolicy mappercache.EvictionPolicy, legacyGSplit bool) *Exporter {
+	e := &Exporter{
+		registry:     registry.NewRegistry(logger),
+		lineCh:       make(chan string),
+		strictMatch:  strictMatch,
+		logger:       logger,
+		sampleExpiry: sampleExpiry,
+		legacyGSplit: legacyGSplit,
+		tagParseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_tag_parse_failures",
+			Help: "Total count of samples with invalid tags",
+		}),
+		lastProcessed: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "graphite_last_processed_timestamp_seconds",
+			Help: "Unix timestamp of the last processed graphite metric.",
+		}),
+		sampleExpiryMetric: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "graphite_sample_expiry_seconds",
+			Help: "How long in seconds a metric sample is valid for.",
+		}),
+		cacheSize:   cacheSize,
+		cachePolicy: cachePolicy,
+	}
+	e.sampleExpiryMetric.Set(sampleExpiry.Seconds())
+	go e.processLines()
+	return e
+}
+
+func (e *Exporter) ExposeTimestamps(exposeTimestamps bool) {
+	e.exposeTimestamps = exposeTimestamps
+}
+
+// SetMapper installs m as the exporter's mapper, wrapping it in a bounded
+// cache first if a cache size was configured via New.
+func (e *Exporter) SetMapper(m Mapper) {
+	if e.cacheSize > 0 {
+		cache := mappercache.New(m, e.cacheSize, e.cachePolicy)
+		e.mapperCache = cache
+		e.mapper = cache
+		return
+	}
+	e.mapper = m
+}
+
+// ProcessReader reads lines from reader and feeds them into the exporter.
+func (e *Exporter) ProcessReader(reader io.Reader) {
+	listener.ProcessReader(reader, e.lineCh)
+}
+
+// ListenTCP opens a Graphite plaintext TCP listener on addr.
+func (e *Exporter) ListenTCP(addr string) error {
+	l := listener.NewTCPListener(e.logger, addr, e.lineCh)
+	if err := l.ListenAndServe(); err != nil {
+		return err
+	}
+	e.listeners = append(e.listeners, l)
+	return nil
+}
+
+// ListenUDP opens a Graphite plaintext UDP listener on addr.
+func (e *Exporter) ListenUDP(addr string) error {
+	l := listener.NewUDPListener(e.logger, addr, e.lineCh)
+	if err := l.ListenAndServe(); err != nil {
+		return err
+	}
+	e.listeners = append(e.listeners, l)
+	return nil
+}
+
+// ListenPickle opens a carbon-relay pickle protocol TCP listener on addr.
+func (e *Exporter) ListenPickle(addr string) error {
+	l := listener.NewPickleListener(e.logger, addr, e.lineCh)
+	if err := l.ListenAndServe(); err != nil {
+		return err
+	}
+	e.listeners = append(e.listeners, l)
+	return nil
+}
+
+func (e *Exporter) processLines() {
+	for l := range e.lineCh {
+		e.processLine(l)
+	}
+}
+
+// resolveMapping looks up the mapping rule for parsedName. Graphite carries
+// no type hint of its own, so we always probe with MetricTypeGauge: a rule
+// that doesn't restrict itself to a type via match_metric_type matches
+// regardless of the probed type, so Gauge is a safe, neutral choice that
+// never fails to find such a rule. The resulting Prometheus metric type is
+// then read off the rule's own MatchMetricType, if it set one; a rule that
+// doesn't restrict itself defaults to Gauge, same as an unmapped metric.
+func (e *Exporter) resolveMapping(parsedName string) (*mapper.MetricMapping, prometheus.Labels, mapper.MetricType, bool) {
+	m, labels, present := e.mapper.GetMapping(parsedName, mapper.MetricTypeGauge)
+	if !present {
+		return nil, nil, mapper.MetricTypeGauge, false
+	}
+	metricType := mapper.MetricTypeGauge
+	if m.MatchMetricType != "" {
+		metricType = m.MatchMetricType
+	}
+	return m, labels, metricType, true
+}
+
+func (e *Exporter) processLine(raw string) {
+	level.Debug(e.logger).Log("msg", "Incoming line", "line", raw)
+
+	pl, err := line.Parse(raw)
+	var tagErr *line.TagParseError
+	if errors.As(err, &tagErr) {
+		e.tagParseFailures.Inc()
+		level.Debug(e.logger).Log("msg", "Invalid tags", "line", raw, "err", tagErr.Error())
+	} else if err != nil {
+		level.Info(e.logger).Log("msg", "Invalid line", "line", raw, "err", err.Error())
+		return
+	}
+
+	mapping, mappingLabels, metricType, mappingPresent := e.resolveMapping(pl.Name)
+	glabels := line.ExtractSegmentLabels(pl.OriginalName, mappingLabels, e.legacyGSplit)
+	labels := line.MergeLabels(pl.Tags, glabels)
+
+	if (mappingPresent && mapping.Action == mapper.ActionTypeDrop) || (!mappingPresent && e.strictMatch) {
+		return
+	}
+
+	var name string
+	if mappingPresent {
+		name = line.InvalidMetricChars.ReplaceAllString(mapping.Name, "_")
+	} else {
+		name = line.InvalidMetricChars.ReplaceAllString(pl.Name, "_")
+	}
+
+	help := "Graphite metric " + name
+	// mapping.Ttl's zero value can't tell "ttl: 0" apart from "no ttl:
+	// field at all", so treat only a positive value as an explicit
+	// override; an absent or zero mapping.Ttl falls back to the global
+	// sampleExpiry default, same as an unmapped metric.
+	ttl := e.sampleExpiry
+	if mappingPresent && mapping.Ttl > 0 {
+		ttl = mapping.Ttl
+	}
+
+	var ev event.Event
+	switch {
+	case mappingPresent && metricType == mapper.MetricTypeCounter:
+		ev = event.NewCounterEvent(name, help, labels, pl.Value, pl.Timestamp, ttl)
+	case mappingPresent && metricType == mapper.MetricTypeTimer:
+		observerType := mapping.ObserverType
+		if observerType != mapper.ObserverTypeHistogram {
+			observerType = mapper.ObserverTypeSummary
+		}
+		var buckets []float64
+		if mapping.HistogramOptions != nil {
+			buckets = mapping.HistogramOptions.Buckets
+		}
+		ev = event.NewTimerEvent(name, help, labels, pl.Value, pl.Timestamp, ttl, observerType, buckets, mapping.SummaryOptions)
+	default:
+		ev = event.NewGaugeEvent(name, help, labels, pl.Value, pl.Timestamp, ttl)
+	}
+
+	level.Debug(e.logger).Log("msg", "Processing sample", "name", name, "value", pl.Value)
+	e.lastProcessed.Set(float64(time.Now().UnixNano()) / 1e9)
+	if err := ev.Handle(e.registry); err != nil {
+		level.Debug(e.logger).Log("msg", "Could not apply event", "name", name, "err", err.Error())
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.lastProcessed.Collect(ch)
+	e.sampleExpiryMetric.Collect(ch)
+	e.tagParseFailures.Collect(ch)
+	e.registry.Collect(ch)
+	for _, l := range e.listeners {
+		l.Collect(ch)
+	}
+	if e.mapperCache != nil {
+		e.mapperCache.Collect(ch)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.lastProcessed.Describe(ch)
+	e.sampleExpiryMetric.Describe(ch)
+	e.tagParseFailures.Describe(ch)
+	e.registry.Describe(ch)
+	for _, l := range e.listeners {
+		l.Describe(ch)
+	}
+	if e.mapperCache != nil {
+		e.mapperCache.Describe(ch)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package line
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// segmentRef matches a "$N" or "${N}" reference to a dotted metric name's
+// 1-based Nth segment, e.g. "$1" or "${1}" for the first segment of
+// "prod.web01.requests".
+var segmentRef = regexp.MustCompile(`\$\{(\d+)\}|\$(\d+)`)
+
+// rawSegmentsValue is the special label value that opts a label's key into
+// "raw segments" mode (see RawSegments) instead of a single substituted
+// value.
+const rawSegmentsValue = "$*"
+
+// substituteSegments replaces every "$N"/"${N}" reference in value with
+// name's 1-based Nth dot segment, e.g. "${1}.${2}.rest" against
+// "prod.web01.requests" yields "prod.web01.rest". A reference outside the
+// segment count substitutes the empty string. A value with no reference at
+// all is returned unchanged, so an ordinary literal label value still works.
+func substituteSegments(value, name string) string {
+	segments := strings.Split(name, ".")
+	return segmentRef.ReplaceAllStringFunc(value, func(ref string) string {
+		numStr := strings.Trim(ref, "${}")
+		idx, _ := strconv.Atoi(numStr)
+		idx--
+		if idx < 0 || idx >= len(segments) {
+			return ""
+		}
+		return segments[idx]
+	})
+}
+
+// RawSegments splits name on "." into one label per segment, named
+// "<prefix>_N". A prefix of "" defaults to "gsplit". This reproduces the
+// exporter's original GSplit behavior, without GSplit's trailing "." on the
+// last segment's value.
+func RawSegments(name, prefix string) map[string]string {
+	if prefix == "" {
+		prefix = "gsplit"
+	}
+	split := strings.Split(name, ".")
+	labels := make(map[string]string, len(split))
+	for i, part := range split {
+		labels[fmt.Sprintf("%s_%d", prefix, i)] = part
+	}
+	return labels
+}
+
+// ExtractSegmentLabels resolves mappingLabels against name's dot segments, a
+// mapping rule sets these the same way it sets any other label:
+//
+//	labels:
+//	  env: "$1"
+//	  instance: "${2}.${3}.rest"
+//
+// matched against "prod.web01.requests.count" yields
+// {"env": "prod", "instance": "web01.requests.rest"}. A label whose value is
+// exactly "$*" instead opts into "raw segments" mode: it is replaced by one
+// "<key>_N" label per dot segment (see RawSegments), using the label's own
+// key as the prefix. A label with no "$" reference passes through
+// unchanged, so ordinary literal labels keep working.
+//
+// legacyGSplit forces the pre-existing always-on "gsplit_N" labels when
+// mappingLabels sets none of this up, for --graphite.legacy-gsplit. These
+// come from RawSegments, so the last value no longer carries the old
+// trailing ".".
+func ExtractSegmentLabels(name string, mappingLabels map[string]string, legacyGSplit bool) map[string]string {
+	if len(mappingLabels) == 0 {
+		if legacyGSplit {
+			return RawSegments(name, "gsplit")
+		}
+		return nil
+	}
+	labels := make(map[string]string, len(mappingLabels))
+	for key, value := range mappingLabels {
+		if value == rawSegmentsValue {
+			for k, v := range RawSegments(name, key) {
+				labels[k] = v
+			}
+			continue
+		}
+		labels[key] = substituteSegments(value, name)
+	}
+	return labels
+}
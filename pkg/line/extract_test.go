@@ -0,0 +1,97 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package line
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRawSegments(t *testing.T) {
+	got := RawSegments("prod.web01.requests", "")
+	want := map[string]string{"gsplit_0": "prod", "gsplit_1": "web01", "gsplit_2": "requests"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RawSegments() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRawSegmentsCustomPrefix(t *testing.T) {
+	got := RawSegments("prod.web01", "seg")
+	want := map[string]string{"seg_0": "prod", "seg_1": "web01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RawSegments() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsNamedPosition(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01.requests", map[string]string{"env": "$1", "host": "$2"}, false)
+	want := map[string]string{"env": "prod", "host": "web01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsNamedPositionOutOfRange(t *testing.T) {
+	got := ExtractSegmentLabels("prod.requests", map[string]string{"env": "$1", "missing": "$5"}, false)
+	want := map[string]string{"env": "prod", "missing": ""}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v, an out-of-range reference should substitute the empty string", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsTemplate(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01.requests", map[string]string{"instance": "${1}.${2}.rest"}, false)
+	want := map[string]string{"instance": "prod.web01.rest"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsLiteralPassesThrough(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01", map[string]string{"team": "platform"}, false)
+	want := map[string]string{"team": "platform"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v, a value with no $ reference should pass through unchanged", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsRawMode(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01", map[string]string{"seg": "$*", "team": "platform"}, false)
+	want := map[string]string{"seg_0": "prod", "seg_1": "web01", "team": "platform"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v, a \"$*\" value should explode into one label per segment", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsNoMappingLabelsNoLegacy(t *testing.T) {
+	if got := ExtractSegmentLabels("prod.web01", nil, false); got != nil {
+		t.Errorf("ExtractSegmentLabels() = %+v, want nil", got)
+	}
+}
+
+func TestExtractSegmentLabelsLegacyGSplit(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01", nil, true)
+	want := map[string]string{"gsplit_0": "prod", "gsplit_1": "web01"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractSegmentLabelsMappingLabelsWinOverLegacy(t *testing.T) {
+	got := ExtractSegmentLabels("prod.web01", map[string]string{"env": "$1"}, true)
+	want := map[string]string{"env": "prod"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ExtractSegmentLabels() = %+v, want %+v, explicit mapping labels should win over legacyGSplit", got, want)
+	}
+}
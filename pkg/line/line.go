@@ -0,0 +1,120 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package line parses Graphite plaintext protocol lines into their
+// constituent name, tags, value and timestamp. Everything here is a pure
+// function: no goroutines, no channels, no Prometheus types, so it can be
+// unit tested and reused by any listener that produces raw lines.
+package line
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InvalidMetricChars matches anything that isn't valid in a Prometheus
+// metric name, so callers can sanitize a mapped or raw name before using it.
+var InvalidMetricChars = regexp.MustCompile("[^a-zA-Z0-9_:]")
+
+// ParsedLine is a single Graphite sample before it has been resolved
+// against a mapper.
+type ParsedLine struct {
+	OriginalName string
+	Name         string
+	Tags         map[string]string
+	Value        float64
+	Timestamp    time.Time
+}
+
+// TagParseError wraps a malformed-tag error. Parse still returns a usable
+// ParsedLine alongside it, since one bad tag shouldn't drop the whole line.
+type TagParseError struct{ err error }
+
+func (e *TagParseError) Error() string { return e.err.Error() }
+func (e *TagParseError) Unwrap() error { return e.err }
+
+// Parse splits a Graphite plaintext line ("name value timestamp") into a
+// ParsedLine. A malformed value, timestamp or part count is a fatal error
+// and returns a zero ParsedLine. A malformed tag is reported as a
+// *TagParseError alongside a usable ParsedLine, so callers can choose to
+// keep processing the line.
+func Parse(raw string) (ParsedLine, error) {
+	raw = strings.TrimSpace(raw)
+	parts := strings.Split(raw, " ")
+	if len(parts) != 3 {
+		return ParsedLine{}, fmt.Errorf("invalid part count, expected 3, got %d", len(parts))
+	}
+
+	originalName := parts[0]
+	name, tags, tagErr := ParseMetricNameAndTags(originalName)
+
+	value, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return ParsedLine{}, fmt.Errorf("invalid value %q: %w", parts[1], err)
+	}
+	timestamp, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return ParsedLine{}, fmt.Errorf("invalid timestamp %q: %w", parts[2], err)
+	}
+
+	pl := ParsedLine{
+		OriginalName: originalName,
+		Name:         name,
+		Tags:         tags,
+		Value:        value,
+		Timestamp:    time.Unix(int64(timestamp), int64(math.Mod(timestamp, 1.0)*1e9)),
+	}
+	if tagErr != nil {
+		return pl, &TagParseError{tagErr}
+	}
+	return pl, nil
+}
+
+// ParseMetricNameAndTags splits a Graphite 1.1 tagged metric name
+// ("name;tag=value;tag=value") into its bare name and its tags. A
+// malformed tag is skipped and reported via the returned error, but parsing
+// continues for the remaining tags.
+func ParseMetricNameAndTags(name string) (string, map[string]string, error) {
+	var err error
+	tags := make(map[string]string)
+
+	parts := strings.Split(name, ";")
+	parsedName := parts[0]
+
+	for _, tag := range parts[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			err = fmt.Errorf("error parsing tag %s", tag)
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return parsedName, tags, err
+}
+
+// MergeLabels merges s2 over s1, with s2 taking precedence on conflicts.
+func MergeLabels(s1, s2 map[string]string) map[string]string {
+	ret := make(map[string]string, len(s1)+len(s2))
+	for k, v := range s1 {
+		ret[k] = v
+	}
+	for k, v := range s2 {
+		ret[k] = v
+	}
+	return ret
+}
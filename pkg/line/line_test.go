@@ -0,0 +1,118 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package line
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	pl, err := Parse("test.metric 1 1433586859")
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	want := ParsedLine{
+		OriginalName: "test.metric",
+		Name:         "test.metric",
+		Tags:         map[string]string{},
+		Value:        1,
+		Timestamp:    time.Unix(1433586859, 0),
+	}
+	if !reflect.DeepEqual(pl, want) {
+		t.Errorf("Parse() = %+v, want %+v", pl, want)
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	pl, err := Parse("test.metric;tag1=value1;tag2=value2 1 1433586859")
+	if err != nil {
+		t.Fatalf("Parse returned an error: %v", err)
+	}
+	if pl.Name != "test.metric" {
+		t.Errorf("Name = %q, want %q", pl.Name, "test.metric")
+	}
+	wantTags := map[string]string{"tag1": "value1", "tag2": "value2"}
+	if !reflect.DeepEqual(pl.Tags, wantTags) {
+		t.Errorf("Tags = %+v, want %+v", pl.Tags, wantTags)
+	}
+}
+
+func TestParseMalformedTagIsNonFatal(t *testing.T) {
+	pl, err := Parse("test.metric;badtag 1 1433586859")
+	var tagErr *TagParseError
+	if !errors.As(err, &tagErr) {
+		t.Fatalf("Parse error = %v, want a *TagParseError", err)
+	}
+	if pl.Name != "test.metric" {
+		t.Errorf("a malformed tag should still yield a usable ParsedLine, got Name = %q", pl.Name)
+	}
+}
+
+func TestParseInvalidPartCount(t *testing.T) {
+	if _, err := Parse("test.metric 1"); err == nil {
+		t.Fatal("expected an error for a line with the wrong part count")
+	}
+}
+
+func TestParseInvalidValue(t *testing.T) {
+	if _, err := Parse("test.metric notanumber 1433586859"); err == nil {
+		t.Fatal("expected an error for a non-numeric value")
+	}
+}
+
+func TestParseInvalidTimestamp(t *testing.T) {
+	if _, err := Parse("test.metric 1 notanumber"); err == nil {
+		t.Fatal("expected an error for a non-numeric timestamp")
+	}
+}
+
+func TestParseMetricNameAndTags(t *testing.T) {
+	name, tags, err := ParseMetricNameAndTags("test.metric;env=prod;host=web01")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "test.metric" {
+		t.Errorf("name = %q, want %q", name, "test.metric")
+	}
+	want := map[string]string{"env": "prod", "host": "web01"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %+v, want %+v", tags, want)
+	}
+}
+
+func TestParseMetricNameAndTagsSkipsMalformed(t *testing.T) {
+	name, tags, err := ParseMetricNameAndTags("test.metric;env=prod;malformed")
+	if err == nil {
+		t.Fatal("expected an error for a malformed tag")
+	}
+	if name != "test.metric" {
+		t.Errorf("name = %q, want %q", name, "test.metric")
+	}
+	if tags["env"] != "prod" {
+		t.Errorf("parsing should continue past the malformed tag, got tags = %+v", tags)
+	}
+}
+
+func TestMergeLabelsOverride(t *testing.T) {
+	s1 := map[string]string{"a": "1", "b": "2"}
+	s2 := map[string]string{"b": "3", "c": "4"}
+	got := MergeLabels(s1, s2)
+	want := map[string]string{"a": "1", "b": "3", "c": "4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeLabels() = %+v, want %+v", got, want)
+	}
+}
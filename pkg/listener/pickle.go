@@ -0,0 +1,286 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// pickleSample is one (name, (timestamp, value)) tuple decoded from a
+// carbon-relay pickle batch.
+type pickleSample struct {
+	Name      string
+	Value     float64
+	Timestamp float64
+}
+
+// pickleMark is a sentinel pushed by the MARK opcode.
+type pickleMark struct{}
+
+// decodePickle interprets just enough of the Python pickle bytecode to read
+// the batches carbon-relay emits: a list of (name, (timestamp, value))
+// tuples. It supports the opcodes carbon's own pickle receiver emits:
+// MARK, (EMPTY_)LIST, TUPLE/TUPLE1/TUPLE2/TUPLE3, SHORT_BINSTRING,
+// BINSTRING, SHORT_BINUNICODE, BININT/BININT1/BININT2/LONG1, BINFLOAT,
+// APPEND/APPENDS and STOP. PROTO, FRAME and the BINPUT/LONG_BINPUT memo
+// opcodes are consumed but otherwise ignored, since we never need to look
+// a memoized object back up.
+func decodePickle(data []byte) ([]pickleSample, error) {
+	var stack []interface{}
+	i := 0
+
+	pop := func() (interface{}, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("pickle: stack underflow")
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+
+	popToMark := func() ([]interface{}, error) {
+		for idx := len(stack) - 1; idx >= 0; idx-- {
+			if _, ok := stack[idx].(pickleMark); ok {
+				items := append([]interface{}{}, stack[idx+1:]...)
+				stack = stack[:idx]
+				return items, nil
+			}
+		}
+		return nil, fmt.Errorf("pickle: no mark on stack")
+	}
+
+	need := func(n int) error {
+		if i+n > len(data) {
+			return fmt.Errorf("pickle: truncated frame, need %d bytes at offset %d", n, i)
+		}
+		return nil
+	}
+
+	for i < len(data) {
+		op := data[i]
+		i++
+		switch op {
+		case 0x80: // PROTO
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			i++
+		case 0x95: // FRAME
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			i += 8
+		case '(': // MARK
+			stack = append(stack, pickleMark{})
+		case ']': // EMPTY_LIST
+			stack = append(stack, []interface{}{})
+		case ')': // EMPTY_TUPLE
+			stack = append(stack, []interface{}{})
+		case 'l': // LIST: pop to mark, push list of popped items
+			items, err := popToMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, items)
+		case 't': // TUPLE: pop to mark, push tuple of popped items
+			items, err := popToMark()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, items)
+		case 0x85: // TUPLE1
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, []interface{}{a})
+		case 0x86: // TUPLE2
+			b, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, []interface{}{a, b})
+		case 0x87: // TUPLE3
+			c, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			b, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, []interface{}{a, b, c})
+		case 'U': // SHORT_BINSTRING
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			n := int(data[i])
+			i++
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(data[i:i+n]))
+			i += n
+		case 'T': // BINSTRING
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			n := int(binary.LittleEndian.Uint32(data[i : i+4]))
+			i += 4
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(data[i:i+n]))
+			i += n
+		case 0x8c: // SHORT_BINUNICODE
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			n := int(data[i])
+			i++
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			stack = append(stack, string(data[i:i+n]))
+			i += n
+		case 'J': // BININT
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			stack = append(stack, float64(int32(binary.LittleEndian.Uint32(data[i:i+4]))))
+			i += 4
+		case 'K': // BININT1
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			stack = append(stack, float64(data[i]))
+			i++
+		case 'M': // BININT2
+			if err := need(2); err != nil {
+				return nil, err
+			}
+			stack = append(stack, float64(binary.LittleEndian.Uint16(data[i:i+2])))
+			i += 2
+		case 0x8a: // LONG1
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			n := int(data[i])
+			i++
+			if err := need(n); err != nil {
+				return nil, err
+			}
+			var v int64
+			for j := n - 1; j >= 0; j-- {
+				v = v<<8 | int64(data[i+j])
+			}
+			stack = append(stack, float64(v))
+			i += n
+		case 'G': // BINFLOAT (big-endian, unlike the integer opcodes)
+			if err := need(8); err != nil {
+				return nil, err
+			}
+			bits := binary.BigEndian.Uint64(data[i : i+8])
+			stack = append(stack, math.Float64frombits(bits))
+			i += 8
+		case 'a': // APPEND: pop value, append to the list below it
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("pickle: APPEND with no list on stack")
+			}
+			list, ok := stack[len(stack)-1].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("pickle: APPEND target is not a list")
+			}
+			stack[len(stack)-1] = append(list, v)
+		case 'e': // APPENDS: pop to mark, append all to the list below it
+			items, err := popToMark()
+			if err != nil {
+				return nil, err
+			}
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("pickle: APPENDS with no list on stack")
+			}
+			list, ok := stack[len(stack)-1].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("pickle: APPENDS target is not a list")
+			}
+			stack[len(stack)-1] = append(list, items...)
+		case 'q': // BINPUT
+			if err := need(1); err != nil {
+				return nil, err
+			}
+			i++
+		case 'r': // LONG_BINPUT
+			if err := need(4); err != nil {
+				return nil, err
+			}
+			i += 4
+		case '.': // STOP
+			v, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			return pickleSamplesFromValue(v)
+		default:
+			return nil, fmt.Errorf("pickle: unsupported opcode 0x%x at offset %d", op, i-1)
+		}
+	}
+	return nil, fmt.Errorf("pickle: frame ended without STOP")
+}
+
+func pickleSamplesFromValue(v interface{}) ([]pickleSample, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("pickle: expected a list of samples at the top level")
+	}
+	samples := make([]pickleSample, 0, len(items))
+	for _, item := range items {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			return nil, fmt.Errorf("pickle: expected a (name, (timestamp, value)) tuple")
+		}
+		name, ok := entry[0].(string)
+		if !ok {
+			return nil, fmt.Errorf("pickle: metric name is not a string")
+		}
+		inner, ok := entry[1].([]interface{})
+		if !ok || len(inner) != 2 {
+			return nil, fmt.Errorf("pickle: expected a (timestamp, value) tuple for %s", name)
+		}
+		timestamp, ok := inner[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("pickle: timestamp for %s is not numeric", name)
+		}
+		value, ok := inner[1].(float64)
+		if !ok {
+			return nil, fmt.Errorf("pickle: value for %s is not numeric", name)
+		}
+		samples = append(samples, pickleSample{Name: name, Value: value, Timestamp: timestamp})
+	}
+	return samples, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxPickleFrameSize bounds how large a single length-prefixed pickle frame
+// we're willing to buffer, so a malformed length prefix can't make us try
+// to allocate gigabytes.
+const maxPickleFrameSize = 64 << 20 // 64 MiB
+
+// PickleListener accepts carbon-relay's pickle protocol: a 4-byte
+// big-endian length prefix followed by that many bytes of pickled
+// [(name, (timestamp, value)), ...] data. Decoded samples are re-rendered
+// as plaintext lines and pushed into the same lineCh as every other
+// listener, so they flow through the usual line/event pipeline.
+type PickleListener struct {
+	logger        log.Logger
+	addr          string
+	lineCh        chan<- string
+	parseFailures prometheus.Counter
+}
+
+// NewPickleListener creates a PickleListener bound to addr (e.g. ":2004").
+func NewPickleListener(logger log.Logger, addr string, lineCh chan<- string) *PickleListener {
+	return &PickleListener{
+		logger: logger,
+		addr:   addr,
+		lineCh: lineCh,
+		parseFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_pickle_parse_failures_total",
+			Help: "Total number of carbon pickle frames that failed to decode.",
+		}),
+	}
+}
+
+// ListenAndServe opens the listening socket and serves connections in the
+// background until the process exits.
+func (l *PickleListener) ListenAndServe() error {
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	go l.serve(ln)
+	return nil
+}
+
+func (l *PickleListener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			level.Error(l.logger).Log("msg", "Error accepting pickle connection", "err", err)
+			continue
+		}
+		go l.handleConn(conn)
+	}
+}
+
+func (l *PickleListener) handleConn(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		frame, err := readPickleFrame(r)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			level.Debug(l.logger).Log("msg", "Error reading pickle frame", "err", err)
+			return
+		}
+		samples, err := decodePickle(frame)
+		if err != nil {
+			l.parseFailures.Inc()
+			level.Debug(l.logger).Log("msg", "Error decoding pickle frame", "err", err)
+			continue
+		}
+		for _, s := range samples {
+			l.lineCh <- fmt.Sprintf("%s %v %v", s.Name, s.Value, s.Timestamp)
+		}
+	}
+}
+
+func readPickleFrame(r *bufio.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	frameLen := binary.BigEndian.Uint32(lenBuf[:])
+	if frameLen > maxPickleFrameSize {
+		return nil, fmt.Errorf("pickle frame of %d bytes exceeds the %d byte limit", frameLen, maxPickleFrameSize)
+	}
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// Describe implements prometheus.Collector.
+func (l *PickleListener) Describe(ch chan<- *prometheus.Desc) { l.parseFailures.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (l *PickleListener) Collect(ch chan<- prometheus.Metric) { l.parseFailures.Collect(ch) }
@@ -0,0 +1,241 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// pickleBuilder assembles a pickle byte stream opcode by opcode, so tests
+// read like the wire format instead of hand-computed byte offsets.
+type pickleBuilder struct {
+	buf bytes.Buffer
+}
+
+func (b *pickleBuilder) proto(version byte) *pickleBuilder {
+	b.buf.WriteByte(0x80)
+	b.buf.WriteByte(version)
+	return b
+}
+
+func (b *pickleBuilder) frame(length uint64) *pickleBuilder {
+	b.buf.WriteByte(0x95)
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], length)
+	b.buf.Write(n[:])
+	return b
+}
+
+func (b *pickleBuilder) mark() *pickleBuilder {
+	b.buf.WriteByte('(')
+	return b
+}
+
+func (b *pickleBuilder) emptyList() *pickleBuilder {
+	b.buf.WriteByte(']')
+	return b
+}
+
+func (b *pickleBuilder) shortBinString(s string) *pickleBuilder {
+	b.buf.WriteByte('U')
+	b.buf.WriteByte(byte(len(s)))
+	b.buf.WriteString(s)
+	return b
+}
+
+func (b *pickleBuilder) binFloat(v float64) *pickleBuilder {
+	b.buf.WriteByte('G')
+	var n [8]byte
+	binary.BigEndian.PutUint64(n[:], math.Float64bits(v))
+	b.buf.Write(n[:])
+	return b
+}
+
+func (b *pickleBuilder) binPut(idx byte) *pickleBuilder {
+	b.buf.WriteByte('q')
+	b.buf.WriteByte(idx)
+	return b
+}
+
+func (b *pickleBuilder) longBinPut(idx uint32) *pickleBuilder {
+	b.buf.WriteByte('r')
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], idx)
+	b.buf.Write(n[:])
+	return b
+}
+
+func (b *pickleBuilder) tuple2() *pickleBuilder {
+	b.buf.WriteByte(0x86)
+	return b
+}
+
+func (b *pickleBuilder) tuple() *pickleBuilder {
+	b.buf.WriteByte('t')
+	return b
+}
+
+func (b *pickleBuilder) appendOp() *pickleBuilder {
+	b.buf.WriteByte('a')
+	return b
+}
+
+func (b *pickleBuilder) appends() *pickleBuilder {
+	b.buf.WriteByte('e')
+	return b
+}
+
+func (b *pickleBuilder) list() *pickleBuilder {
+	b.buf.WriteByte('l')
+	return b
+}
+
+func (b *pickleBuilder) stop() *pickleBuilder {
+	b.buf.WriteByte('.')
+	return b
+}
+
+func (b *pickleBuilder) sample(name string, ts, value float64) *pickleBuilder {
+	return b.shortBinString(name).
+		binPut(1).
+		binFloat(ts).
+		binFloat(value).
+		tuple2().
+		longBinPut(2).
+		tuple2()
+}
+
+func (b *pickleBuilder) bytes() []byte { return b.buf.Bytes() }
+
+func TestDecodePickleMultiSampleBatch(t *testing.T) {
+	data := (&pickleBuilder{}).
+		proto(2).
+		frame(0).
+		mark().
+		sample("a.b.c", 1433586859, 1.5).
+		sample("d.e.f", 1433586860, 2.5).
+		list().
+		stop().
+		bytes()
+
+	samples, err := decodePickle(data)
+	if err != nil {
+		t.Fatalf("decodePickle returned an error: %v", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples, want 2", len(samples))
+	}
+	if samples[0].Name != "a.b.c" || samples[0].Timestamp != 1433586859 || samples[0].Value != 1.5 {
+		t.Errorf("samples[0] = %+v, want {a.b.c 1.5 1433586859}", samples[0])
+	}
+	if samples[1].Name != "d.e.f" || samples[1].Timestamp != 1433586860 || samples[1].Value != 2.5 {
+		t.Errorf("samples[1] = %+v, want {d.e.f 2.5 1433586860}", samples[1])
+	}
+}
+
+// TestDecodePickleAppendOpcodes exercises the EMPTY_LIST/APPEND/APPENDS path,
+// which carbon-relay's own pickler uses for batches above a certain size
+// instead of MARK+LIST.
+func TestDecodePickleAppendOpcodes(t *testing.T) {
+	data := (&pickleBuilder{}).
+		proto(2).
+		emptyList().
+		sample("a.b.c", 1433586859, 1.5).
+		appendOp().
+		mark().
+		sample("d.e.f", 1433586860, 2.5).
+		sample("g.h.i", 1433586861, 3.5).
+		appends().
+		stop().
+		bytes()
+
+	samples, err := decodePickle(data)
+	if err != nil {
+		t.Fatalf("decodePickle returned an error: %v", err)
+	}
+	if len(samples) != 3 {
+		t.Fatalf("got %d samples, want 3", len(samples))
+	}
+	if samples[0].Name != "a.b.c" {
+		t.Errorf("samples[0].Name = %q, want a.b.c (APPEND should push a single item)", samples[0].Name)
+	}
+	if samples[1].Name != "d.e.f" || samples[2].Name != "g.h.i" {
+		t.Errorf("samples[1:] = %+v, want [d.e.f g.h.i] (APPENDS should push every marked item)", samples[1:])
+	}
+}
+
+func TestDecodePickleTupleOpcode(t *testing.T) {
+	// The mark-based 't' TUPLE opcode can wrap the top-level batch instead
+	// of 'l' LIST; decodePickle treats both the same way.
+	data := (&pickleBuilder{}).
+		mark().
+		sample("a.b.c", 1433586859, 1.5).
+		tuple().
+		stop().
+		bytes()
+
+	samples, err := decodePickle(data)
+	if err != nil {
+		t.Fatalf("decodePickle returned an error: %v", err)
+	}
+	if len(samples) != 1 || samples[0].Name != "a.b.c" || samples[0].Value != 1.5 {
+		t.Errorf("samples = %+v, want one sample {a.b.c ... 1.5}", samples)
+	}
+}
+
+func TestDecodePickleTruncatedFrame(t *testing.T) {
+	data := []byte{'U', 10, 'a', 'b'} // SHORT_BINSTRING claims 10 bytes, only 2 follow
+	if _, err := decodePickle(data); err == nil {
+		t.Fatal("expected an error for a truncated frame")
+	}
+}
+
+func TestDecodePickleUnsupportedOpcode(t *testing.T) {
+	if _, err := decodePickle([]byte{0xFF}); err == nil {
+		t.Fatal("expected an error for an unsupported opcode")
+	}
+}
+
+func TestDecodePickleStackUnderflow(t *testing.T) {
+	if _, err := decodePickle([]byte{'a'}); err == nil { // APPEND with nothing on the stack
+		t.Fatal("expected an error for APPEND with an empty stack")
+	}
+}
+
+func TestDecodePickleRejectsNonListTopLevel(t *testing.T) {
+	data := (&pickleBuilder{}).binFloat(1.0).stop().bytes()
+	if _, err := decodePickle(data); err == nil {
+		t.Fatal("expected an error when the top-level pickled value isn't a list")
+	}
+}
+
+func TestDecodePickleRejectsNonNumericTimestampOrValue(t *testing.T) {
+	data := (&pickleBuilder{}).
+		mark().
+		shortBinString("a.b.c").
+		binPut(1).
+		shortBinString("notanumber"). // timestamp and value must be numeric, not strings
+		shortBinString("alsonotanumber").
+		tuple2().
+		tuple2().
+		list().
+		stop().
+		bytes()
+	if _, err := decodePickle(data); err == nil {
+		t.Fatal("expected an error for a non-numeric timestamp/value")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package listener owns the sources that feed raw Graphite lines into the
+// exporter: readers handed to us by a caller (ProcessReader), and native
+// TCP, UDP and carbon pickle sockets (TCPListener, UDPListener,
+// PickleListener). Every listener's only job is to push complete lines onto
+// a channel; parsing and mapping happen downstream.
+package listener
+
+import (
+	"bufio"
+	"io"
+)
+
+// ProcessReader reads newline-delimited Graphite plaintext lines from
+// reader and pushes each one onto lineCh until reader is exhausted.
+func ProcessReader(reader io.Reader, lineCh chan<- string) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		lineCh <- scanner.Text()
+	}
+}
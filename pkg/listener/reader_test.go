@@ -0,0 +1,42 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessReader(t *testing.T) {
+	lineCh := make(chan string)
+	go ProcessReader(strings.NewReader("a 1 1\nb 2 2\n"), lineCh)
+
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case l := <-lineCh:
+			got = append(got, l)
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for a line")
+		}
+	}
+
+	want := []string{"a 1 1", "b 2 2"}
+	for i, l := range want {
+		if got[i] != l {
+			t.Errorf("line %d = %q, want %q", i, got[i], l)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"net"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TCPListener accepts Graphite plaintext connections, one metric per line,
+// and feeds every line it reads into lineCh.
+type TCPListener struct {
+	logger      log.Logger
+	addr        string
+	lineCh      chan<- string
+	connections prometheus.Gauge
+}
+
+// NewTCPListener creates a TCPListener bound to addr (e.g. ":2003").
+func NewTCPListener(logger log.Logger, addr string, lineCh chan<- string) *TCPListener {
+	return &TCPListener{
+		logger: logger,
+		addr:   addr,
+		lineCh: lineCh,
+		connections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "graphite_tcp_connections",
+			Help: "Current number of open Graphite plaintext TCP connections.",
+		}),
+	}
+}
+
+// ListenAndServe opens the listening socket and serves connections in the
+// background until the process exits.
+func (l *TCPListener) ListenAndServe() error {
+	ln, err := net.Listen("tcp", l.addr)
+	if err != nil {
+		return err
+	}
+	go l.serve(ln)
+	return nil
+}
+
+func (l *TCPListener) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			level.Error(l.logger).Log("msg", "Error accepting TCP connection", "err", err)
+			continue
+		}
+		l.connections.Inc()
+		go func(c net.Conn) {
+			defer c.Close()
+			defer l.connections.Dec()
+			ProcessReader(c, l.lineCh)
+		}(conn)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (l *TCPListener) Describe(ch chan<- *prometheus.Desc) { l.connections.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (l *TCPListener) Collect(ch chan<- prometheus.Metric) { l.connections.Collect(ch) }
@@ -0,0 +1,88 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package listener
+
+import (
+	"net"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxUDPPacketSize is larger than any single UDP datagram can be, so one
+// ReadFromUDP call always reads a whole packet.
+const maxUDPPacketSize = 65535
+
+// UDPListener accepts Graphite plaintext packets. Each packet may carry one
+// or more newline-separated metric lines.
+type UDPListener struct {
+	logger  log.Logger
+	addr    string
+	lineCh  chan<- string
+	packets prometheus.Counter
+}
+
+// NewUDPListener creates a UDPListener bound to addr (e.g. ":2003").
+func NewUDPListener(logger log.Logger, addr string, lineCh chan<- string) *UDPListener {
+	return &UDPListener{
+		logger: logger,
+		addr:   addr,
+		lineCh: lineCh,
+		packets: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_udp_packets_total",
+			Help: "Total number of Graphite UDP packets processed.",
+		}),
+	}
+}
+
+// ListenAndServe opens the listening socket and serves packets in the
+// background until the process exits.
+func (l *UDPListener) ListenAndServe() error {
+	addr, err := net.ResolveUDPAddr("udp", l.addr)
+	if err != nil {
+		return err
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	go l.serve(conn)
+	return nil
+}
+
+func (l *UDPListener) serve(conn *net.UDPConn) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			level.Error(l.logger).Log("msg", "Error reading UDP packet", "err", err)
+			continue
+		}
+		l.packets.Inc()
+		for _, ln := range strings.Split(strings.TrimSpace(string(buf[:n])), "\n") {
+			if ln == "" {
+				continue
+			}
+			l.lineCh <- ln
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (l *UDPListener) Describe(ch chan<- *prometheus.Desc) { l.packets.Describe(ch) }
+
+// Collect implements prometheus.Collector.
+func (l *UDPListener) Collect(ch chan<- prometheus.Metric) { l.packets.Collect(ch) }
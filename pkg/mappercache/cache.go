@@ -0,0 +1,206 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mappercache wraps a mapper.Mapper with a bounded, in-process
+// cache of GetMapping results, so high-cardinality metric names don't pay
+// for a glob/regex match on every line. Negative "no match" results are
+// cached too, since a miss is exactly as expensive to re-evaluate as a hit.
+package mappercache
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+// EvictionPolicy selects how the cache picks a victim once it's full.
+type EvictionPolicy string
+
+const (
+	// EvictionLRU evicts the least recently used entry.
+	EvictionLRU EvictionPolicy = "lru"
+	// EvictionRR evicts a random entry. Go randomizes map iteration
+	// order, so picking the first key seen is an O(1) random pick, with
+	// hit rates competitive with LRU under high-cardinality workloads.
+	EvictionRR EvictionPolicy = "rr"
+)
+
+// Mapper is the subset of mapper.Mapper that Cache wraps.
+type Mapper interface {
+	GetMapping(string, mapper.MetricType) (*mapper.MetricMapping, prometheus.Labels, bool)
+	InitFromFile(fileName string) error
+}
+
+type result struct {
+	mapping *mapper.MetricMapping
+	labels  prometheus.Labels
+	present bool
+}
+
+type entry struct {
+	key     string
+	result  result
+	element *list.Element // only used by EvictionLRU
+}
+
+// Cache wraps a Mapper with a bounded cache of its GetMapping results.
+type Cache struct {
+	mu      sync.Mutex
+	mapper  Mapper
+	size    int
+	policy  EvictionPolicy
+	entries map[string]*entry
+	order   *list.List // MRU at the front; only used by EvictionLRU
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+	sizeGauge prometheus.Gauge
+}
+
+// New wraps m with a cache bounded to size entries using policy. A size of
+// 0 or less disables caching: every call passes straight through to m.
+func New(m Mapper, size int, policy EvictionPolicy) *Cache {
+	return &Cache{
+		mapper:  m,
+		size:    size,
+		policy:  policy,
+		entries: make(map[string]*entry),
+		order:   list.New(),
+		hits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_mapper_cache_hits_total",
+			Help: "Total number of metric mapper cache hits.",
+		}),
+		misses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_mapper_cache_misses_total",
+			Help: "Total number of metric mapper cache misses.",
+		}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_mapper_cache_evictions_total",
+			Help: "Total number of metric mapper cache entries evicted to make room.",
+		}),
+		sizeGauge: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "graphite_mapper_cache_size",
+			Help: "Current number of entries held in the metric mapper cache.",
+		}),
+	}
+}
+
+func cacheKey(name string, metricType mapper.MetricType) string {
+	return fmt.Sprintf("%v\x00%s", metricType, name)
+}
+
+// GetMapping implements mapper.Mapper, serving from cache when possible.
+func (c *Cache) GetMapping(name string, metricType mapper.MetricType) (*mapper.MetricMapping, prometheus.Labels, bool) {
+	if c.size <= 0 {
+		return c.mapper.GetMapping(name, metricType)
+	}
+
+	key := cacheKey(name, metricType)
+
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok {
+		if c.policy == EvictionLRU {
+			c.order.MoveToFront(e.element)
+		}
+		c.hits.Inc()
+		res := e.result
+		c.mu.Unlock()
+		return res.mapping, res.labels, res.present
+	}
+	c.mu.Unlock()
+
+	c.misses.Inc()
+	mapping, labels, present := c.mapper.GetMapping(name, metricType)
+
+	c.mu.Lock()
+	c.insert(key, result{mapping: mapping, labels: labels, present: present})
+	c.mu.Unlock()
+
+	return mapping, labels, present
+}
+
+// insert adds key to the cache, evicting an entry first if full. Caller
+// must hold c.mu.
+func (c *Cache) insert(key string, res result) {
+	if e, ok := c.entries[key]; ok {
+		e.result = res
+		return
+	}
+	if len(c.entries) >= c.size {
+		c.evict()
+	}
+	e := &entry{key: key, result: res}
+	if c.policy == EvictionLRU {
+		e.element = c.order.PushFront(key)
+	}
+	c.entries[key] = e
+	c.sizeGauge.Set(float64(len(c.entries)))
+}
+
+// evict removes one entry according to c.policy. Caller must hold c.mu.
+func (c *Cache) evict() {
+	var key string
+	switch c.policy {
+	case EvictionLRU:
+		back := c.order.Back()
+		if back == nil {
+			return
+		}
+		key = back.Value.(string)
+		c.order.Remove(back)
+	default: // EvictionRR
+		for k := range c.entries {
+			key = k
+			break
+		}
+	}
+	delete(c.entries, key)
+	c.evictions.Inc()
+}
+
+// InitFromFile implements mapper.Mapper, clearing the cache after a reload
+// since every existing entry may now resolve to a different mapping.
+func (c *Cache) InitFromFile(fileName string) error {
+	err := c.mapper.InitFromFile(fileName)
+	c.Clear()
+	return err
+}
+
+// Clear empties the cache, e.g. after the mapping configuration reloads.
+func (c *Cache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]*entry)
+	c.order.Init()
+	c.sizeGauge.Set(0)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Cache) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+	c.misses.Collect(ch)
+	c.evictions.Collect(ch)
+	c.sizeGauge.Collect(ch)
+}
+
+// Describe implements prometheus.Collector.
+func (c *Cache) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+	c.misses.Describe(ch)
+	c.evictions.Describe(ch)
+	c.sizeGauge.Describe(ch)
+}
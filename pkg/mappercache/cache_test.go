@@ -0,0 +1,139 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mappercache
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+// fakeMapper counts calls per metric name so tests can tell a cache hit
+// (no call reaches the wrapped Mapper) from a miss.
+type fakeMapper struct {
+	calls    map[string]int
+	mappings map[string]*mapper.MetricMapping
+}
+
+func newFakeMapper() *fakeMapper {
+	return &fakeMapper{calls: make(map[string]int), mappings: make(map[string]*mapper.MetricMapping)}
+}
+
+func (f *fakeMapper) GetMapping(name string, metricType mapper.MetricType) (*mapper.MetricMapping, prometheus.Labels, bool) {
+	f.calls[name]++
+	m, ok := f.mappings[name]
+	return m, nil, ok
+}
+
+func (f *fakeMapper) InitFromFile(string) error { return nil }
+
+func TestGetMappingCachesHitsAndMisses(t *testing.T) {
+	fm := newFakeMapper()
+	fm.mappings["test.metric"] = &mapper.MetricMapping{Name: "test_metric"}
+	c := New(fm, 10, EvictionLRU)
+
+	for i := 0; i < 3; i++ {
+		if _, _, present := c.GetMapping("test.metric", mapper.MetricTypeGauge); !present {
+			t.Fatal("expected a mapping to be present")
+		}
+	}
+	if fm.calls["test.metric"] != 1 {
+		t.Errorf("wrapped mapper called %d times, want 1 (the rest should be cache hits)", fm.calls["test.metric"])
+	}
+}
+
+func TestGetMappingCachesNegativeResults(t *testing.T) {
+	fm := newFakeMapper()
+	c := New(fm, 10, EvictionLRU)
+
+	for i := 0; i < 3; i++ {
+		if _, _, present := c.GetMapping("unmapped.metric", mapper.MetricTypeGauge); present {
+			t.Fatal("expected no mapping to be present")
+		}
+	}
+	if fm.calls["unmapped.metric"] != 1 {
+		t.Errorf("wrapped mapper called %d times, want 1, a miss should be cached too", fm.calls["unmapped.metric"])
+	}
+}
+
+func TestGetMappingBypassesCacheWhenSizeIsZero(t *testing.T) {
+	fm := newFakeMapper()
+	fm.mappings["test.metric"] = &mapper.MetricMapping{Name: "test_metric"}
+	c := New(fm, 0, EvictionLRU)
+
+	for i := 0; i < 3; i++ {
+		c.GetMapping("test.metric", mapper.MetricTypeGauge)
+	}
+	if fm.calls["test.metric"] != 3 {
+		t.Errorf("wrapped mapper called %d times, want 3, size 0 should disable caching", fm.calls["test.metric"])
+	}
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	fm := newFakeMapper()
+	for _, name := range []string{"a", "b", "c"} {
+		fm.mappings[name] = &mapper.MetricMapping{Name: name}
+	}
+	c := New(fm, 2, EvictionLRU)
+
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	c.GetMapping("b", mapper.MetricTypeGauge)
+	c.GetMapping("a", mapper.MetricTypeGauge) // touch a, making b the LRU entry
+	c.GetMapping("c", mapper.MetricTypeGauge) // should evict b, not a
+
+	fm.calls["a"] = 0
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	if fm.calls["a"] != 0 {
+		t.Error("a should still be cached after c was inserted")
+	}
+
+	fm.calls["b"] = 0
+	c.GetMapping("b", mapper.MetricTypeGauge)
+	if fm.calls["b"] != 1 {
+		t.Error("b should have been evicted as the least recently used entry")
+	}
+}
+
+func TestClearEmptiesCache(t *testing.T) {
+	fm := newFakeMapper()
+	fm.mappings["a"] = &mapper.MetricMapping{Name: "a"}
+	c := New(fm, 10, EvictionLRU)
+
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	c.Clear()
+
+	fm.calls["a"] = 0
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	if fm.calls["a"] != 1 {
+		t.Error("Clear should drop all cached entries")
+	}
+}
+
+func TestInitFromFileClearsCache(t *testing.T) {
+	fm := newFakeMapper()
+	fm.mappings["a"] = &mapper.MetricMapping{Name: "a"}
+	c := New(fm, 10, EvictionLRU)
+
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	if err := c.InitFromFile("mapping.yml"); err != nil {
+		t.Fatalf("InitFromFile returned an error: %v", err)
+	}
+
+	fm.calls["a"] = 0
+	c.GetMapping("a", mapper.MetricTypeGauge)
+	if fm.calls["a"] != 1 {
+		t.Error("InitFromFile should clear every existing cache entry")
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry owns the live Prometheus collectors backing every mapped
+// Graphite series: one prometheus.Counter/Gauge/Histogram/Summary per
+// (name, label set), plus TTL tracking and a sweeper that evicts series
+// that have gone stale. It implements event.Registry, so an event.Event
+// never has to know how metrics are stored.
+package registry
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+// sweepInterval is how often the registry looks for series past their TTL.
+const sweepInterval = 30 * time.Second
+
+type metricKind int
+
+const (
+	kindGauge metricKind = iota
+	kindCounter
+	kindHistogram
+	kindSummary
+)
+
+// seriesEntry tracks when a series was last touched and how long it may go
+// without an update before the sweeper evicts it. A ttl <= 0 means "never
+// expire".
+type seriesEntry struct {
+	kind       metricKind
+	name       string
+	labels     prometheus.Labels
+	ttl        time.Duration
+	lastUpdate time.Time
+}
+
+// Registry is a prometheus.Collector that lazily creates and stores one
+// metric vector per mapped name, and the individual series within it.
+type Registry struct {
+	mu     sync.Mutex
+	logger log.Logger
+
+	gauges     map[string]*prometheus.GaugeVec
+	counters   map[string]*prometheus.CounterVec
+	histograms map[string]*prometheus.HistogramVec
+	summaries  map[string]*prometheus.SummaryVec
+
+	// labelNames remembers the sorted label keys each metric name was
+	// first registered with, so a later sample with a different tag set
+	// is rejected instead of panicking inside the client library.
+	labelNames map[string][]string
+
+	series map[string]*seriesEntry
+
+	evictions prometheus.Counter
+	active    prometheus.Gauge
+
+	stopCh chan struct{}
+}
+
+// NewRegistry creates a Registry and starts its background expiry sweeper.
+func NewRegistry(logger log.Logger) *Registry {
+	r := &Registry{
+		logger:     logger,
+		gauges:     make(map[string]*prometheus.GaugeVec),
+		counters:   make(map[string]*prometheus.CounterVec),
+		histograms: make(map[string]*prometheus.HistogramVec),
+		summaries:  make(map[string]*prometheus.SummaryVec),
+		labelNames: make(map[string][]string),
+		series:     make(map[string]*seriesEntry),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "graphite_metric_evictions_total",
+			Help: "Total number of series evicted for exceeding their TTL.",
+		}),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "graphite_metrics_active",
+			Help: "Number of distinct series currently tracked by the registry.",
+		}),
+		stopCh: make(chan struct{}),
+	}
+	go r.sweepLoop()
+	return r
+}
+
+// Stop terminates the background expiry sweeper.
+func (r *Registry) Stop() {
+	close(r.stopCh)
+}
+
+func sortedKeys(labels prometheus.Labels) []string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func seriesKey(name string, labels prometheus.Labels) string {
+	keys := sortedKeys(labels)
+	kv := make([]string, len(keys))
+	for i, k := range keys {
+		kv[i] = k + "=" + labels[k]
+	}
+	return name + "{" + strings.Join(kv, ",") + "}"
+}
+
+// checkLabelNames reports whether labels matches the label set a metric name
+// was first registered with, registering it on first use. Caller must hold
+// r.mu.
+func (r *Registry) checkLabelNames(name string, labels prometheus.Labels) bool {
+	keys := sortedKeys(labels)
+	if existing, ok := r.labelNames[name]; ok {
+		if strings.Join(existing, ",") != strings.Join(keys, ",") {
+			level.Info(r.logger).Log("msg", "Inconsistent label set for metric, dropping sample", "metric", name, "labels", strings.Join(keys, ","))
+			return false
+		}
+		return true
+	}
+	r.labelNames[name] = keys
+	return true
+}
+
+// touch records that a series was just updated, so the sweeper knows it is
+// still alive. Caller must hold r.mu.
+func (r *Registry) touch(kind metricKind, name string, labels prometheus.Labels, ttl time.Duration) {
+	key := seriesKey(name, labels)
+	entry, ok := r.series[key]
+	if !ok {
+		entry = &seriesEntry{kind: kind, name: name, labels: labels}
+		r.series[key] = entry
+		r.active.Set(float64(len(r.series)))
+	}
+	entry.ttl = ttl
+	entry.lastUpdate = time.Now()
+}
+
+// GetCounter implements event.Registry.
+func (r *Registry) GetCounter(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Counter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkLabelNames(name, labels) {
+		return nil, fmt.Errorf("inconsistent label set for metric %s", name)
+	}
+	c, ok := r.counters[name]
+	if !ok {
+		c = prometheus.NewCounterVec(prometheus.CounterOpts{Name: name, Help: help}, sortedKeys(labels))
+		r.counters[name] = c
+	}
+	r.touch(kindCounter, name, labels, ttl)
+	return c.With(labels), nil
+}
+
+// GetGauge implements event.Registry.
+func (r *Registry) GetGauge(name, help string, labels prometheus.Labels, ttl time.Duration) (prometheus.Gauge, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkLabelNames(name, labels) {
+		return nil, fmt.Errorf("inconsistent label set for metric %s", name)
+	}
+	g, ok := r.gauges[name]
+	if !ok {
+		g = prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: name, Help: help}, sortedKeys(labels))
+		r.gauges[name] = g
+	}
+	r.touch(kindGauge, name, labels, ttl)
+	return g.With(labels), nil
+}
+
+// GetHistogram implements event.Registry.
+func (r *Registry) GetHistogram(name, help string, labels prometheus.Labels, buckets []float64, ttl time.Duration) (prometheus.Observer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkLabelNames(name, labels) {
+		return nil, fmt.Errorf("inconsistent label set for metric %s", name)
+	}
+	h, ok := r.histograms[name]
+	if !ok {
+		opts := prometheus.HistogramOpts{Name: name, Help: help}
+		if len(buckets) > 0 {
+			opts.Buckets = buckets
+		}
+		h = prometheus.NewHistogramVec(opts, sortedKeys(labels))
+		r.histograms[name] = h
+	}
+	r.touch(kindHistogram, name, labels, ttl)
+	return h.With(labels), nil
+}
+
+// GetSummary implements event.Registry.
+func (r *Registry) GetSummary(name, help string, labels prometheus.Labels, opts *mapper.SummaryOptions, ttl time.Duration) (prometheus.Observer, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.checkLabelNames(name, labels) {
+		return nil, fmt.Errorf("inconsistent label set for metric %s", name)
+	}
+	s, ok := r.summaries[name]
+	if !ok {
+		sOpts := prometheus.SummaryOpts{Name: name, Help: help}
+		if opts != nil {
+			objectives := make(map[float64]float64, len(opts.Quantiles))
+			for _, q := range opts.Quantiles {
+				objectives[q.Quantile] = q.Error
+			}
+			if len(objectives) > 0 {
+				sOpts.Objectives = objectives
+			}
+			if opts.MaxAge > 0 {
+				sOpts.MaxAge = opts.MaxAge
+			}
+			if opts.AgeBuckets > 0 {
+				sOpts.AgeBuckets = opts.AgeBuckets
+			}
+			if opts.BufCap > 0 {
+				sOpts.BufCap = opts.BufCap
+			}
+		}
+		s = prometheus.NewSummaryVec(sOpts, sortedKeys(labels))
+		r.summaries[name] = s
+	}
+	r.touch(kindSummary, name, labels, ttl)
+	return s.With(labels), nil
+}
+
+func (r *Registry) sweepLoop() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.sweep(time.Now())
+		case <-r.stopCh:
+			return
+		}
+	}
+}
+
+func (r *Registry) sweep(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, entry := range r.series {
+		if entry.ttl <= 0 || now.Sub(entry.lastUpdate) < entry.ttl {
+			continue
+		}
+		switch entry.kind {
+		case kindGauge:
+			if g, ok := r.gauges[entry.name]; ok {
+				g.Delete(entry.labels)
+			}
+		case kindCounter:
+			if c, ok := r.counters[entry.name]; ok {
+				c.Delete(entry.labels)
+			}
+		case kindHistogram:
+			if h, ok := r.histograms[entry.name]; ok {
+				h.Delete(entry.labels)
+			}
+		case kindSummary:
+			if s, ok := r.summaries[entry.name]; ok {
+				s.Delete(entry.labels)
+			}
+		}
+		delete(r.series, key)
+		r.evictions.Inc()
+	}
+	r.active.Set(float64(len(r.series)))
+}
+
+// Collect implements prometheus.Collector.
+func (r *Registry) Collect(ch chan<- prometheus.Metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.gauges {
+		g.Collect(ch)
+	}
+	for _, c := range r.counters {
+		c.Collect(ch)
+	}
+	for _, h := range r.histograms {
+		h.Collect(ch)
+	}
+	for _, s := range r.summaries {
+		s.Collect(ch)
+	}
+	r.evictions.Collect(ch)
+	r.active.Collect(ch)
+}
+
+// Describe implements prometheus.Collector.
+func (r *Registry) Describe(ch chan<- *prometheus.Desc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, g := range r.gauges {
+		g.Describe(ch)
+	}
+	for _, c := range r.counters {
+		c.Describe(ch)
+	}
+	for _, h := range r.histograms {
+		h.Describe(ch)
+	}
+	for _, s := range r.summaries {
+		s.Describe(ch)
+	}
+	r.evictions.Describe(ch)
+	r.active.Describe(ch)
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func newTestRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry(log.NewNopLogger())
+	t.Cleanup(r.Stop)
+	return r
+}
+
+func TestGetGaugeReusesVecAcrossLabelSets(t *testing.T) {
+	r := newTestRegistry(t)
+	if _, err := r.GetGauge("g", "help", prometheus.Labels{"env": "prod"}, 0); err != nil {
+		t.Fatalf("GetGauge returned an error: %v", err)
+	}
+	if _, err := r.GetGauge("g", "help", prometheus.Labels{"env": "staging"}, 0); err != nil {
+		t.Fatalf("GetGauge returned an error: %v", err)
+	}
+	if len(r.gauges) != 1 {
+		t.Errorf("got %d gauge vecs, want 1 shared vec across label values", len(r.gauges))
+	}
+	if len(r.series) != 2 {
+		t.Errorf("got %d tracked series, want 2", len(r.series))
+	}
+}
+
+func TestGetCounterRejectsInconsistentLabelSet(t *testing.T) {
+	r := newTestRegistry(t)
+	if _, err := r.GetCounter("c", "help", prometheus.Labels{"env": "prod"}, 0); err != nil {
+		t.Fatalf("GetCounter returned an error: %v", err)
+	}
+	if _, err := r.GetCounter("c", "help", prometheus.Labels{"host": "web01"}, 0); err == nil {
+		t.Error("expected an error for a metric name reused with a different label set")
+	}
+}
+
+func TestSweepEvictsExpiredSeries(t *testing.T) {
+	r := newTestRegistry(t)
+	labels := prometheus.Labels{"env": "prod"}
+	if _, err := r.GetGauge("g", "help", labels, time.Minute); err != nil {
+		t.Fatalf("GetGauge returned an error: %v", err)
+	}
+
+	r.mu.Lock()
+	for _, entry := range r.series {
+		entry.lastUpdate = time.Now().Add(-2 * time.Minute)
+	}
+	r.mu.Unlock()
+
+	r.sweep(time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.series) != 0 {
+		t.Errorf("got %d tracked series after sweep, want 0", len(r.series))
+	}
+	if got := counterValue(r.evictions); got != 1 {
+		t.Errorf("evictions = %v, want 1", got)
+	}
+}
+
+func TestSweepKeepsNeverExpireSeries(t *testing.T) {
+	r := newTestRegistry(t)
+	labels := prometheus.Labels{"env": "prod"}
+	if _, err := r.GetGauge("g", "help", labels, 0); err != nil {
+		t.Fatalf("GetGauge returned an error: %v", err)
+	}
+
+	r.mu.Lock()
+	for _, entry := range r.series {
+		entry.lastUpdate = time.Now().Add(-24 * time.Hour)
+	}
+	r.mu.Unlock()
+
+	r.sweep(time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.series) != 1 {
+		t.Errorf("got %d tracked series after sweep, want 1, a ttl <= 0 series should never be evicted", len(r.series))
+	}
+}
+
+func TestSweepKeepsFreshSeries(t *testing.T) {
+	r := newTestRegistry(t)
+	labels := prometheus.Labels{"env": "prod"}
+	if _, err := r.GetGauge("g", "help", labels, time.Hour); err != nil {
+		t.Fatalf("GetGauge returned an error: %v", err)
+	}
+
+	r.sweep(time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.series) != 1 {
+		t.Errorf("got %d tracked series after sweep, want 1, a series within its ttl should survive", len(r.series))
+	}
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	var m dto.Metric
+	c.Write(&m)
+	return m.GetCounter().GetValue()
+}